@@ -0,0 +1,134 @@
+package keyfile
+
+import (
+	"fmt"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+// PolicyBranchSelector picks which of a key's AuthPolicy branches should be
+// satisfied at use time, returning its index into branches.
+type PolicyBranchSelector func(branches []*TPMAuthPolicy) (int, error)
+
+// WithPolicyBranchSelector sets the callback used to choose an AuthPolicy
+// branch when the key has more than one.
+func WithPolicyBranchSelector(fn PolicyBranchSelector) TPMKeyOption {
+	return func(t *TPMKey) {
+		t.policyBranchSelector = fn
+	}
+}
+
+// AddAuthPolicy appends policies to the key's AuthPolicy as a new named
+// branch. Use AuthPolicyDigests afterwards to compute the branch digests
+// needed to build the object's authPolicy.
+func (t *TPMKey) AddAuthPolicy(name string, policies []*TPMPolicy) {
+	t.AuthPolicy = append(t.AuthPolicy, &TPMAuthPolicy{
+		Name:   name,
+		Policy: policies,
+	})
+}
+
+// AuthPolicyDigests computes the policy digest of every AuthPolicy branch by
+// replaying each branch's commands against a trial session, in branch order.
+func (t *TPMKey) AuthPolicyDigests(alg tpm2.TPMAlgID) ([][]byte, error) {
+	digests := make([][]byte, 0, len(t.AuthPolicy))
+	for _, branch := range t.AuthPolicy {
+		digest, err := branchDigest(alg, branch.Policy)
+		if err != nil {
+			return nil, fmt.Errorf("branch %q: %v", branch.Name, err)
+		}
+		digests = append(digests, digest)
+	}
+	return digests, nil
+}
+
+// branchDigest computes the policy digest a branch's commands would produce
+// by running them against an in-memory trial session.
+func branchDigest(alg tpm2.TPMAlgID, policies []*TPMPolicy) ([]byte, error) {
+	sess := tpm2.NewTrialSession(alg)
+	for _, p := range policies {
+		if err := updateTrialSession(sess, p); err != nil {
+			return nil, fmt.Errorf("command code 0x%x: %v", p.CommandCode, err)
+		}
+	}
+	return sess.PolicyDigest(), nil
+}
+
+// selectAuthPolicyBranch runs the configured PolicyBranchSelector, defaulting
+// to the sole branch when only one exists, and returns the chosen branch.
+func (t *TPMKey) selectAuthPolicyBranch() (*TPMAuthPolicy, error) {
+	if len(t.AuthPolicy) == 0 {
+		return nil, fmt.Errorf("key has no auth policy branches")
+	}
+	if len(t.AuthPolicy) == 1 {
+		return t.AuthPolicy[0], nil
+	}
+	if t.policyBranchSelector == nil {
+		return nil, fmt.Errorf("key has multiple auth policy branches but no PolicyBranchSelector was configured")
+	}
+	idx, err := t.policyBranchSelector(t.AuthPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("policy branch selector failed: %v", err)
+	}
+	if idx < 0 || idx >= len(t.AuthPolicy) {
+		return nil, fmt.Errorf("policy branch selector returned out of range index %d", idx)
+	}
+	return t.AuthPolicy[idx], nil
+}
+
+// SatisfyAuthPolicy selects an AuthPolicy branch (via PolicyBranchSelector),
+// replays its commands against sess, and issues TPM2_PolicyOR across every
+// branch digest so the resulting session satisfies the object's authPolicy.
+func (t *TPMKey) SatisfyAuthPolicy(tpm transport.TPMCloser, sess tpm2.Session, hooks PolicyHooks) error {
+	branch, err := t.selectAuthPolicyBranch()
+	if err != nil {
+		return err
+	}
+	if err := executePolicy(tpm, sess, branch.Policy, hooks); err != nil {
+		return fmt.Errorf("branch %q: %v", branch.Name, err)
+	}
+
+	digests, err := t.AuthPolicyDigests(sess.HashAlg())
+	if err != nil {
+		return fmt.Errorf("failed computing branch digests: %v", err)
+	}
+
+	hashList := make([]tpm2.TPM2BDigest, 0, len(digests))
+	for _, d := range digests {
+		hashList = append(hashList, tpm2.TPM2BDigest{Buffer: d})
+	}
+
+	_, err = tpm2.PolicyOR{
+		PolicySession: sess.Handle(),
+		PHashList:     tpm2.TPMLDigest{Digests: hashList},
+	}.Execute(tpm)
+	if err != nil {
+		return fmt.Errorf("TPM2_PolicyOR failed: %v", err)
+	}
+	return nil
+}
+
+// resolveAuthSession picks the session a sign/unseal operation against t
+// should authorize with: branch selection plus PolicyOR when t has named
+// AuthPolicy branches, flat Policy replay via PolicySession when it has an
+// ungrouped Policy, or (nil, nil, nil) when it has neither and the caller
+// should fall back to plain password/HMAC auth instead.
+func (t *TPMKey) resolveAuthSession(tpm transport.TPMCloser) (tpm2.Session, func() error, error) {
+	switch {
+	case len(t.AuthPolicy) > 0:
+		sess, closer, err := tpm2.PolicySession(tpm, tpm2.TPMAlgSHA256, 16)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed starting policy session: %v", err)
+		}
+		if err := t.SatisfyAuthPolicy(tpm, sess, t.policyHooks); err != nil {
+			closer()
+			return nil, nil, fmt.Errorf("failed satisfying auth policy: %v", err)
+		}
+		return sess, closer, nil
+	case len(t.Policy) > 0:
+		return t.PolicySession(tpm, t.policyHooks)
+	default:
+		return nil, nil, nil
+	}
+}
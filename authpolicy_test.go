@@ -0,0 +1,105 @@
+package keyfile
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+func authValuePolicy() []*TPMPolicy {
+	return []*TPMPolicy{{CommandCode: int(tpm2.TPMCCPolicyAuthValue)}}
+}
+
+func commandCodePolicy(cc tpm2.TPMCC) []*TPMPolicy {
+	return []*TPMPolicy{{
+		CommandCode:   int(tpm2.TPMCCPolicyCommandCode),
+		CommandPolicy: tpm2.Marshal(tpm2.PolicyCommandCode{Code: cc}),
+	}}
+}
+
+func TestAuthPolicyDigestsDistinctPerBranch(t *testing.T) {
+	var key TPMKey
+	key.AddAuthPolicy("password", authValuePolicy())
+	key.AddAuthPolicy("sign-only", commandCodePolicy(tpm2.TPMCCSign))
+
+	digests, err := key.AuthPolicyDigests(tpm2.TPMAlgSHA256)
+	if err != nil {
+		t.Fatalf("failed computing branch digests: %v", err)
+	}
+	if len(digests) != 2 {
+		t.Fatalf("got %d digests, want 2", len(digests))
+	}
+	if bytes.Equal(digests[0], digests[1]) {
+		t.Fatalf("branches with different commands produced the same digest")
+	}
+
+	// Recomputing must be deterministic.
+	again, err := key.AuthPolicyDigests(tpm2.TPMAlgSHA256)
+	if err != nil {
+		t.Fatalf("failed recomputing branch digests: %v", err)
+	}
+	if !bytes.Equal(digests[0], again[0]) || !bytes.Equal(digests[1], again[1]) {
+		t.Fatalf("AuthPolicyDigests is not deterministic")
+	}
+}
+
+func TestSelectAuthPolicyBranchSingleBranch(t *testing.T) {
+	var key TPMKey
+	key.AddAuthPolicy("only", authValuePolicy())
+
+	branch, err := key.selectAuthPolicyBranch()
+	if err != nil {
+		t.Fatalf("failed selecting branch: %v", err)
+	}
+	if branch.Name != "only" {
+		t.Fatalf("selected branch %q, want %q", branch.Name, "only")
+	}
+}
+
+func TestSelectAuthPolicyBranchNoBranches(t *testing.T) {
+	var key TPMKey
+	if _, err := key.selectAuthPolicyBranch(); err == nil {
+		t.Fatalf("expected an error selecting a branch with no AuthPolicy")
+	}
+}
+
+func TestSelectAuthPolicyBranchRequiresSelector(t *testing.T) {
+	var key TPMKey
+	key.AddAuthPolicy("a", authValuePolicy())
+	key.AddAuthPolicy("b", commandCodePolicy(tpm2.TPMCCSign))
+
+	if _, err := key.selectAuthPolicyBranch(); err == nil {
+		t.Fatalf("expected an error selecting among multiple branches with no PolicyBranchSelector")
+	}
+
+	key.AddOptions(WithPolicyBranchSelector(func(branches []*TPMAuthPolicy) (int, error) {
+		for i, b := range branches {
+			if b.Name == "b" {
+				return i, nil
+			}
+		}
+		return 0, nil
+	}))
+
+	branch, err := key.selectAuthPolicyBranch()
+	if err != nil {
+		t.Fatalf("failed selecting branch: %v", err)
+	}
+	if branch.Name != "b" {
+		t.Fatalf("selected branch %q, want %q", branch.Name, "b")
+	}
+}
+
+func TestSelectAuthPolicyBranchOutOfRange(t *testing.T) {
+	var key TPMKey
+	key.AddAuthPolicy("a", authValuePolicy())
+	key.AddAuthPolicy("b", commandCodePolicy(tpm2.TPMCCSign))
+	key.AddOptions(WithPolicyBranchSelector(func(branches []*TPMAuthPolicy) (int, error) {
+		return len(branches), nil
+	}))
+
+	if _, err := key.selectAuthPolicyBranch(); err == nil {
+		t.Fatalf("expected an error for an out-of-range branch selection")
+	}
+}
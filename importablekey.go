@@ -0,0 +1,290 @@
+package keyfile
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"hash"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/uzb-dev/go-tpm-keyfiles/tpmcmd"
+	"github.com/uzb-dev/go-tpm-keyfiles/tpmcmd/session"
+)
+
+// duplicateLabel is the fixed label the TPM spec uses when wrapping the
+// outer duplication seed itself; rsaOAEPWrap uses it as the RSA-OAEP label.
+const duplicateLabel = "DUPLICATE"
+
+// storageLabel is the fixed KDFa label the TPM spec uses when deriving the
+// outer-wrap symmetric key for a duplicated sensitive area from the seed
+// (the outer HMAC key is derived under "INTEGRITY" instead). Using anything
+// other than "STORAGE" here means a real TPM's TPM2_Import will derive a
+// different key than the one used to produce dupSensitive below, and the
+// import will fail.
+const storageLabel = "STORAGE"
+
+// NewImportableKey wraps sensitive, whose public counterpart is pub, for
+// import onto a TPM whose storage parent has public area parentPub,
+// performing the outer-wrap duplication the spec requires: a random seed is
+// generated and wrapped in parentPub's asymmetric key to produce the
+// TPM2B_ENCRYPTED_SECRET, an AES session key is derived from that seed with
+// KDFa under the "STORAGE" label using the duplicated object's own Name as
+// context, the sensitive area is CFB-encrypted under it, and an outer HMAC
+// (also seed-derived, and likewise bound to the object's Name) is placed in
+// front of it to detect tampering. The result is stored as
+// Pubkey/Secret/Privkey on an OIDImportbleKey TPMKey. loadTPMKey runs
+// TPM2_Import against the real parent each time it loads such a key; of the
+// TPMKey methods, only the policy signer that Signer returns for keys with a
+// Policy or AuthPolicy loads through it, and Unseal accepts only
+// OIDSealedKey keys.
+func NewImportableKey(parentPub, pub *tpm2.TPMTPublic, sensitive *tpm2.TPMTSensitive, opts ...TPMKeyOption) (*TPMKey, error) {
+	objectName, err := publicName(pub)
+	if err != nil {
+		return nil, fmt.Errorf("failed computing object name: %v", err)
+	}
+
+	seed, encryptedSecret, err := wrapDuplicationSeed(parentPub)
+	if err != nil {
+		return nil, fmt.Errorf("failed wrapping duplication seed: %v", err)
+	}
+
+	symBits, err := symKeyBits(parentPub)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting parent symmetric key size: %v", err)
+	}
+
+	duplicate, err := duplicateSensitive(parentPub.NameAlg, seed, objectName, symBits, sensitive)
+	if err != nil {
+		return nil, fmt.Errorf("failed duplicating sensitive area: %v", err)
+	}
+
+	key := NewTPMKey(OIDImportbleKey, tpm2.New2B(*pub), tpm2.TPM2BPrivate{Buffer: duplicate}, opts...)
+	key.Secret = tpm2.TPM2BEncryptedSecret{Buffer: encryptedSecret}
+	return key, nil
+}
+
+// duplicateSensitive performs the outer-wrap of sensitive under seed,
+// producing the marshaled TPM2B_PRIVATE (outer HMAC followed by the
+// CFB-encrypted sensitive area) that a target TPM's TPM2_Import expects.
+// Per the spec both the symmetric key and the outer HMAC must be bound to
+// objectName, the Name of the object being duplicated, not the parent's.
+func duplicateSensitive(nameAlg tpm2.TPMAlgID, seed, objectName []byte, symBits int, sensitive *tpm2.TPMTSensitive) ([]byte, error) {
+	symKey, err := tpm2.KDFa(nameAlg, seed, storageLabel, objectName, nil, symBits)
+	if err != nil {
+		return nil, fmt.Errorf("failed deriving outer symmetric key: %v", err)
+	}
+
+	dupSensitive, err := cfbEncrypt(symKey, tpm2.Marshal(tpm2.TPM2BPrivate{Buffer: tpm2.Marshal(sensitive)}))
+	if err != nil {
+		return nil, fmt.Errorf("failed encrypting duplicated sensitive area: %v", err)
+	}
+
+	hmacKey, err := tpm2.KDFa(nameAlg, seed, "INTEGRITY", nil, nil, hashSize(nameAlg)*8)
+	if err != nil {
+		return nil, fmt.Errorf("failed deriving outer hmac key: %v", err)
+	}
+	outerHMAC, err := outerIntegrity(nameAlg, hmacKey, dupSensitive, objectName)
+	if err != nil {
+		return nil, fmt.Errorf("failed computing outer integrity hmac: %v", err)
+	}
+
+	// The TPM's duplicated-object wire format is integrityOuter (a sized
+	// TPM2B_DIGEST) followed by encSensitive, not bare HMAC bytes: without
+	// the length prefix a real TPM misparses the HMAC as a bogus size field
+	// and fails outer-integrity verification.
+	integrityOuter := tpm2.Marshal(tpm2.TPM2BDigest{Buffer: outerHMAC})
+	return tpm2.Marshal(tpm2.TPM2BPrivate{Buffer: append(integrityOuter, dupSensitive...)}), nil
+}
+
+// importUnderParent runs TPM2_Import, via the tpmcmd direct-style command
+// API, to turn an OIDImportbleKey's duplicated blob into a regular loadable
+// private area under parentHandle. loadImportable calls it before every
+// TPM2_Load of such a key; the resulting private area is not stored on t.
+func importUnderParent(tpm transport.TPMCloser, parentHandle tpm2.TPMHandle, parentAuth []byte, t *TPMKey) (tpm2.TPM2BPrivate, error) {
+	if !t.Keytype.Equal(OIDImportbleKey) {
+		return tpm2.TPM2BPrivate{}, fmt.Errorf("key is not an importable key")
+	}
+
+	importRsp, err := tpmcmd.Import.Execute(tpm, session.Password(parentAuth), tpmcmd.ImportReq{
+		ParentHandle: parentHandle,
+		ObjectPublic: t.Pubkey,
+		Duplicate:    t.Privkey,
+		InSymSeed:    t.Secret,
+	})
+	if err != nil {
+		return tpm2.TPM2BPrivate{}, fmt.Errorf("failed importing key: %v", err)
+	}
+	return importRsp.OutPrivate, nil
+}
+
+// loadImportable imports and then loads an OIDImportbleKey,
+// returning a handle usable like any other loaded TPM object.
+func loadImportable(tpm transport.TPMCloser, parentHandle tpm2.TPMHandle, parentAuth []byte, t *TPMKey) (tpm2.TPMHandle, error) {
+	privkey, err := importUnderParent(tpm, parentHandle, parentAuth, t)
+	if err != nil {
+		return 0, err
+	}
+	loadRsp, err := tpmcmd.LoadKey.Execute(tpm, session.Password(parentAuth), tpmcmd.LoadKeyReq{
+		ParentHandle: parentHandle,
+		InPublic:     t.Pubkey,
+		InPrivate:    privkey,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed loading imported key: %v", err)
+	}
+	return loadRsp.ObjectHandle, nil
+}
+
+// loadTPMKey loads t under parentHandle, running TPM2_Import first when t is
+// an OIDImportbleKey (whose Privkey is still the wrapped duplicate blob, not
+// something TPM2_Load can consume directly), and TPM2_Load alone otherwise.
+func loadTPMKey(tpm transport.TPMCloser, parentHandle tpm2.TPMHandle, parentAuth []byte, t *TPMKey) (tpm2.TPMHandle, error) {
+	if t.Keytype.Equal(OIDImportbleKey) {
+		return loadImportable(tpm, parentHandle, parentAuth, t)
+	}
+	loadRsp, err := tpmcmd.LoadKey.Execute(tpm, session.Password(parentAuth), tpmcmd.LoadKeyReq{
+		ParentHandle: parentHandle,
+		InPublic:     t.Pubkey,
+		InPrivate:    t.Privkey,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed loading key: %v", err)
+	}
+	return loadRsp.ObjectHandle, nil
+}
+
+// symKeyBits returns the key size, in bits, of the outer-wrap symmetric
+// algorithm a duplication under parentPub must use: the parent's own
+// symDef, per the spec, not a hardcoded guess. ECC storage parents aren't
+// supported for import yet; see wrapDuplicationSeed.
+func symKeyBits(parentPub *tpm2.TPMTPublic) (int, error) {
+	switch parentPub.Type {
+	case tpm2.TPMAlgRSA:
+		rsaDetail, err := parentPub.Parameters.RSADetail()
+		if err != nil {
+			return 0, fmt.Errorf("failed getting rsa details: %v", err)
+		}
+		return symDefKeyBits(rsaDetail.Symmetric)
+	default:
+		return 0, fmt.Errorf("unsupported parent key algorithm for import")
+	}
+}
+
+// symDefKeyBits reads the key size, in bits, out of a TPMT_SYM_DEF_OBJECT's
+// algorithm-specific KeyBits union.
+func symDefKeyBits(sym tpm2.TPMTSymDefObject) (int, error) {
+	switch sym.Algorithm {
+	case tpm2.TPMAlgAES:
+		bits, err := sym.KeyBits.AES()
+		if err != nil {
+			return 0, fmt.Errorf("failed getting aes key bits: %v", err)
+		}
+		return int(*bits), nil
+	default:
+		return 0, fmt.Errorf("unsupported parent symmetric algorithm")
+	}
+}
+
+func hashSize(alg tpm2.TPMAlgID) int {
+	switch alg {
+	case tpm2.TPMAlgSHA1:
+		return 20
+	case tpm2.TPMAlgSHA384:
+		return 48
+	case tpm2.TPMAlgSHA512:
+		return 64
+	default:
+		return 32 // SHA256
+	}
+}
+
+func newHash(alg tpm2.TPMAlgID) (func() hash.Hash, error) {
+	h, err := alg.Hash()
+	if err != nil {
+		return nil, fmt.Errorf("unsupported name algorithm: %v", err)
+	}
+	return h.New, nil
+}
+
+func publicName(pub *tpm2.TPMTPublic) ([]byte, error) {
+	newHashFn, err := newHash(pub.NameAlg)
+	if err != nil {
+		return nil, err
+	}
+	h := newHashFn()
+	h.Write(tpm2.Marshal(pub))
+	digest := h.Sum(nil)
+	return append(tpm2.Marshal(pub.NameAlg), digest...), nil
+}
+
+// wrapDuplicationSeed generates a random duplication seed and wraps it under
+// parentPub's asymmetric public key, producing the TPM2B_ENCRYPTED_SECRET
+// the target TPM unwraps with its private key during TPM2_Import.
+func wrapDuplicationSeed(parentPub *tpm2.TPMTPublic) (seed, encryptedSecret []byte, err error) {
+	seed = make([]byte, hashSize(parentPub.NameAlg))
+	if _, err := rand.Read(seed); err != nil {
+		return nil, nil, fmt.Errorf("failed generating seed: %v", err)
+	}
+
+	switch parentPub.Type {
+	case tpm2.TPMAlgRSA:
+		rsaDetail, err := parentPub.Parameters.RSADetail()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed getting rsa details: %v", err)
+		}
+		rsaUnique, err := parentPub.Unique.RSA()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed getting rsa unique: %v", err)
+		}
+		parentRSA, err := tpm2.RSAPub(rsaDetail, rsaUnique)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed building rsa pubkey: %v", err)
+		}
+		encryptedSecret, err = rsaOAEPWrap(parentRSA, parentPub.NameAlg, seed)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed wrapping seed with rsa-oaep: %v", err)
+		}
+	case tpm2.TPMAlgECC:
+		return nil, nil, fmt.Errorf("ecc storage parents are not yet supported for import")
+	default:
+		return nil, nil, fmt.Errorf("unsupported parent key algorithm for import")
+	}
+
+	return seed, encryptedSecret, nil
+}
+
+// rsaOAEPWrap encrypts seed under parentRSA using RSA-OAEP with the "DUPLICATE"
+// label, as TPM2_Import expects for an RSA storage parent.
+func rsaOAEPWrap(parentRSA *rsa.PublicKey, nameAlg tpm2.TPMAlgID, seed []byte) ([]byte, error) {
+	newHashFn, err := newHash(nameAlg)
+	if err != nil {
+		return nil, err
+	}
+	return rsa.EncryptOAEP(newHashFn(), rand.Reader, parentRSA, seed, []byte(duplicateLabel+"\x00"))
+}
+
+func cfbEncrypt(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating aes cipher: %v", err)
+	}
+	iv := make([]byte, block.BlockSize())
+	out := make([]byte, len(plaintext))
+	cipher.NewCFBEncrypter(block, iv).XORKeyStream(out, plaintext)
+	return out, nil
+}
+
+func outerIntegrity(alg tpm2.TPMAlgID, key, dupSensitive, objectName []byte) ([]byte, error) {
+	newHashFn, err := newHash(alg)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(newHashFn, key)
+	mac.Write(dupSensitive)
+	mac.Write(objectName)
+	return mac.Sum(nil), nil
+}
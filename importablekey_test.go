@@ -0,0 +1,155 @@
+package keyfile
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+// cfbDecrypt reverses cfbEncrypt (AES-CFB with a zero IV).
+func cfbDecrypt(key, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(ciphertext))
+	cipher.NewCFBDecrypter(block, make([]byte, block.BlockSize())).XORKeyStream(out, ciphertext)
+	return out, nil
+}
+
+func TestCFBEncryptRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 16)
+	plaintext := []byte("some sensitive area bytes")
+
+	ciphertext, err := cfbEncrypt(key, plaintext)
+	if err != nil {
+		t.Fatalf("failed encrypting: %v", err)
+	}
+	if bytes.Equal(ciphertext, plaintext) {
+		t.Fatalf("ciphertext should not equal plaintext")
+	}
+
+	// CFB feeds ciphertext back into the keystream, so recovering the
+	// plaintext takes a CFB decrypter with the same key/IV.
+	roundTripped, err := cfbDecrypt(key, ciphertext)
+	if err != nil {
+		t.Fatalf("failed decrypting: %v", err)
+	}
+	if !bytes.Equal(roundTripped, plaintext) {
+		t.Fatalf("round trip mismatch: got %x want %x", roundTripped, plaintext)
+	}
+}
+
+func TestOuterIntegrityDeterministic(t *testing.T) {
+	key := bytes.Repeat([]byte{0x24}, 32)
+	dupSensitive := []byte("duplicated sensitive area")
+	parentName := []byte("parent name")
+
+	mac1, err := outerIntegrity(tpm2.TPMAlgSHA256, key, dupSensitive, parentName)
+	if err != nil {
+		t.Fatalf("failed computing hmac: %v", err)
+	}
+	mac2, err := outerIntegrity(tpm2.TPMAlgSHA256, key, dupSensitive, parentName)
+	if err != nil {
+		t.Fatalf("failed computing hmac: %v", err)
+	}
+	if !bytes.Equal(mac1, mac2) {
+		t.Fatalf("outerIntegrity is not deterministic")
+	}
+
+	tampered, err := outerIntegrity(tpm2.TPMAlgSHA256, key, append(dupSensitive, 0x00), parentName)
+	if err != nil {
+		t.Fatalf("failed computing hmac: %v", err)
+	}
+	if bytes.Equal(mac1, tampered) {
+		t.Fatalf("outerIntegrity did not change for tampered input")
+	}
+}
+
+func TestDuplicateSensitiveBoundToObjectName(t *testing.T) {
+	seed := bytes.Repeat([]byte{0x11}, 32)
+	sensitive := &tpm2.TPMTSensitive{
+		SensitiveType: tpm2.TPMAlgKeyedHash,
+		SeedValue:     tpm2.TPM2BDigest{Buffer: bytes.Repeat([]byte{0x22}, 32)},
+	}
+
+	objectName := []byte("object name")
+	parentName := []byte("parent name")
+
+	duplicate, err := duplicateSensitive(tpm2.TPMAlgSHA256, seed, objectName, 128, sensitive)
+	if err != nil {
+		t.Fatalf("failed duplicating sensitive area: %v", err)
+	}
+
+	// Regression test: TPM2_Import computes the outer HMAC (and derives the
+	// outer symmetric key) over the duplicated object's own Name, not the
+	// parent's. Swapping in parentName must produce a different blob, or a
+	// real TPM's outer-integrity check on duplicate would fail.
+	duplicateWithParentName, err := duplicateSensitive(tpm2.TPMAlgSHA256, seed, parentName, 128, sensitive)
+	if err != nil {
+		t.Fatalf("failed duplicating sensitive area: %v", err)
+	}
+	if bytes.Equal(duplicate, duplicateWithParentName) {
+		t.Fatalf("duplicate did not change when the binding name changed")
+	}
+
+	// Reproduce the unwrap an importing TPM would do, using objectName, and
+	// confirm the sensitive area round-trips and the outer HMAC verifies.
+	// integrityOuter is a sized TPM2B_DIGEST, not bare HMAC bytes, so it
+	// must be unmarshaled rather than sliced at a hardcoded offset.
+	var unwrapped tpm2.TPM2BPrivate
+	if _, err := tpm2.Unmarshal(duplicate, &unwrapped); err != nil {
+		t.Fatalf("failed unmarshaling duplicate: %v", err)
+	}
+	var integrityOuter tpm2.TPM2BDigest
+	rest, err := tpm2.Unmarshal(unwrapped.Buffer, &integrityOuter)
+	if err != nil {
+		t.Fatalf("failed unmarshaling integrityOuter: %v", err)
+	}
+	outerHMAC, dupSensitive := integrityOuter.Buffer, rest
+
+	hmacKey, err := tpm2.KDFa(tpm2.TPMAlgSHA256, seed, "INTEGRITY", nil, nil, hashSize(tpm2.TPMAlgSHA256)*8)
+	if err != nil {
+		t.Fatalf("failed deriving outer hmac key: %v", err)
+	}
+	wantHMAC, err := outerIntegrity(tpm2.TPMAlgSHA256, hmacKey, dupSensitive, objectName)
+	if err != nil {
+		t.Fatalf("failed recomputing outer hmac: %v", err)
+	}
+	if !bytes.Equal(outerHMAC, wantHMAC) {
+		t.Fatalf("outer hmac does not verify against objectName")
+	}
+
+	symKey, err := tpm2.KDFa(tpm2.TPMAlgSHA256, seed, storageLabel, objectName, nil, 128)
+	if err != nil {
+		t.Fatalf("failed deriving outer symmetric key: %v", err)
+	}
+	plaintext, err := cfbDecrypt(symKey, dupSensitive)
+	if err != nil {
+		t.Fatalf("failed decrypting duplicated sensitive area: %v", err)
+	}
+	var recovered tpm2.TPM2BPrivate
+	if _, err := tpm2.Unmarshal(plaintext, &recovered); err != nil {
+		t.Fatalf("failed unmarshaling recovered sensitive area: %v", err)
+	}
+	if !bytes.Equal(recovered.Buffer, tpm2.Marshal(sensitive)) {
+		t.Fatalf("recovered sensitive area does not match original")
+	}
+}
+
+func TestStorageLabelDistinctFromDuplicateLabel(t *testing.T) {
+	// Regression test: the outer-wrap symmetric key must be derived under
+	// the "STORAGE" label, not "DUPLICATE" (which is reserved for wrapping
+	// the seed itself via RSA-OAEP). Using the same label for both makes a
+	// real TPM derive a different key than NewImportableKey did, and every
+	// TPM2_Import would fail.
+	if storageLabel == duplicateLabel {
+		t.Fatalf("storageLabel must differ from duplicateLabel")
+	}
+	if storageLabel != "STORAGE" {
+		t.Fatalf("storageLabel = %q, want %q", storageLabel, "STORAGE")
+	}
+}
@@ -34,7 +34,13 @@ type TPMKey struct {
 	Parent      tpm2.TPMHandle
 	Pubkey      tpm2.TPM2BPublic
 	Privkey     tpm2.TPM2BPrivate
-	userAuth    []byte // Internal detail
+	// EncryptedPayload holds the AES-GCM sealed application data for an
+	// OIDSealedKey, with the nonce prepended. Only meaningful when Keytype
+	// is OIDSealedKey; see NewSealedKey and TPMKey.Unseal.
+	EncryptedPayload     []byte
+	userAuth             []byte               // Internal detail
+	policyHooks          PolicyHooks          // Internal detail, see WithPolicySignedSigner/WithPolicySecretAuth
+	policyBranchSelector PolicyBranchSelector // Internal detail, see WithPolicyBranchSelector
 }
 
 func NewTPMKey(oid encasn1.ObjectIdentifier, pubkey tpm2.TPM2BPublic, privkey tpm2.TPM2BPrivate, fn ...TPMKeyOption) *TPMKey {
@@ -158,11 +164,18 @@ func (t *TPMKey) PublicKey() (any, error) {
 
 // Wraps TPMSigner with some sane defaults
 // Use NewTPMSigner if you need more control of the parameters
+//
+// Keys carrying a Policy or AuthPolicy are signed through policySigner
+// instead, so every Sign call replays (or branch-selects) the key's policy
+// into a session rather than sending auth as a plain password.
 func (t *TPMKey) Signer(tpm transport.TPMCloser, ownerAuth, auth []byte) (crypto.Signer, error) {
 	if !t.HasSinger() {
 		// TODO: Implement support for signing with Decrypt operations
 		return nil, fmt.Errorf("does not have sign/encrypt attribute set")
 	}
+	if len(t.Policy) > 0 || len(t.AuthPolicy) > 0 {
+		return &policySigner{t: t, tpm: tpm, parentAuth: ownerAuth}, nil
+	}
 	return NewTPMKeySigner(
 		t,
 		func() ([]byte, error) { return ownerAuth, nil },
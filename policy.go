@@ -0,0 +1,199 @@
+package keyfile
+
+import (
+	"fmt"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+// PolicySignedSignerCallback produces the TPMTSignature required to satisfy
+// a TPM2_PolicySigned assertion for an externally held (non-TPM) signing key.
+// It is handed the nonceTPM/cpHashA/policyRef/expiration values exactly as
+// they are about to be sent to the TPM so the caller can sign over them.
+type PolicySignedSignerCallback func(nonceTPM, cpHashA, policyRef []byte, expiration int32) (tpm2.TPMTSignature, error)
+
+// PolicySecretAuthCallback returns the auth value used to satisfy a
+// TPM2_PolicySecret assertion against the handle it is invoked for.
+type PolicySecretAuthCallback func(handle tpm2.TPMHandle) ([]byte, error)
+
+// PolicyHooks carries the callbacks needed to replay a TPMKey's Policy
+// against a live TPM. Hooks left nil will cause their corresponding
+// CommandCode to fail if the policy actually requires them.
+type PolicyHooks struct {
+	PolicySignedSigner PolicySignedSignerCallback
+	PolicySecretAuth   PolicySecretAuthCallback
+}
+
+// WithPolicySignedSigner sets the callback used to satisfy TPM2_PolicySigned
+// assertions found in the key's Policy.
+func WithPolicySignedSigner(fn PolicySignedSignerCallback) TPMKeyOption {
+	return func(t *TPMKey) {
+		t.policyHooks.PolicySignedSigner = fn
+	}
+}
+
+// WithPolicySecretAuth sets the callback used to satisfy TPM2_PolicySecret
+// assertions found in the key's Policy.
+func WithPolicySecretAuth(fn PolicySecretAuthCallback) TPMKeyOption {
+	return func(t *TPMKey) {
+		t.policyHooks.PolicySecretAuth = fn
+	}
+}
+
+// PolicySession opens a policy session and replays t.Policy against it,
+// returning the resulting session for use as an auth session on a
+// subsequent sign/unseal/load command. The caller must invoke the returned
+// closer once done with the session.
+func (t *TPMKey) PolicySession(tpm transport.TPMCloser, hooks PolicyHooks) (tpm2.Session, func() error, error) {
+	sess, closer, err := tpm2.PolicySession(tpm, tpm2.TPMAlgSHA256, 16)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed starting policy session: %v", err)
+	}
+	if err := executePolicy(tpm, sess, t.Policy, hooks); err != nil {
+		closer()
+		return nil, nil, fmt.Errorf("failed executing policy: %v", err)
+	}
+	return sess, closer, nil
+}
+
+// executePolicy replays an ordered list of TPMPolicy commands against an
+// already-open policy session.
+func executePolicy(tpm transport.TPMCloser, sess tpm2.Session, policies []*TPMPolicy, hooks PolicyHooks) error {
+	for _, p := range policies {
+		if err := executePolicyCommand(tpm, sess, p, hooks); err != nil {
+			return fmt.Errorf("command code 0x%x: %v", p.CommandCode, err)
+		}
+	}
+	return nil
+}
+
+func executePolicyCommand(tpm transport.TPMCloser, sess tpm2.Session, p *TPMPolicy, hooks PolicyHooks) error {
+	switch tpm2.TPMCC(p.CommandCode) {
+	case tpm2.TPMCCPolicyPCR:
+		var params tpm2.PolicyPCR
+		if _, err := tpm2.Unmarshal(p.CommandPolicy, &params); err != nil {
+			return fmt.Errorf("unmarshal PolicyPCR params: %v", err)
+		}
+		params.PolicySession = sess.Handle()
+		_, err := params.Execute(tpm)
+		return err
+	case tpm2.TPMCCPolicyAuthValue:
+		_, err := tpm2.PolicyAuthValue{PolicySession: sess.Handle()}.Execute(tpm)
+		return err
+	case tpm2.TPMCCPolicyCommandCode:
+		var params tpm2.PolicyCommandCode
+		if _, err := tpm2.Unmarshal(p.CommandPolicy, &params); err != nil {
+			return fmt.Errorf("unmarshal PolicyCommandCode params: %v", err)
+		}
+		params.PolicySession = sess.Handle()
+		_, err := params.Execute(tpm)
+		return err
+	case tpm2.TPMCCPolicyLocality:
+		var params tpm2.PolicyLocality
+		if _, err := tpm2.Unmarshal(p.CommandPolicy, &params); err != nil {
+			return fmt.Errorf("unmarshal PolicyLocality params: %v", err)
+		}
+		params.PolicySession = sess.Handle()
+		_, err := params.Execute(tpm)
+		return err
+	case tpm2.TPMCCPolicySecret:
+		return executePolicySecret(tpm, sess, p, hooks)
+	case tpm2.TPMCCPolicySigned:
+		return executePolicySigned(tpm, sess, p, hooks)
+	case tpm2.TPMCCPolicyOR:
+		var params tpm2.PolicyOR
+		if _, err := tpm2.Unmarshal(p.CommandPolicy, &params); err != nil {
+			return fmt.Errorf("unmarshal PolicyOR params: %v", err)
+		}
+		params.PolicySession = sess.Handle()
+		_, err := params.Execute(tpm)
+		return err
+	default:
+		return fmt.Errorf("unsupported policy command code")
+	}
+}
+
+func executePolicySecret(tpm transport.TPMCloser, sess tpm2.Session, p *TPMPolicy, hooks PolicyHooks) error {
+	var params tpm2.PolicySecret
+	if _, err := tpm2.Unmarshal(p.CommandPolicy, &params); err != nil {
+		return fmt.Errorf("unmarshal PolicySecret params: %v", err)
+	}
+	if hooks.PolicySecretAuth == nil {
+		return fmt.Errorf("policy requires PolicySecret but no PolicySecretAuth hook was provided")
+	}
+	auth, err := hooks.PolicySecretAuth(params.AuthHandle.Handle)
+	if err != nil {
+		return fmt.Errorf("policy secret auth callback failed: %v", err)
+	}
+	params.AuthHandle.Auth = tpm2.PasswordAuth(auth)
+	params.PolicySession = sess.Handle()
+	_, err = params.Execute(tpm)
+	return err
+}
+
+// updateTrialSession feeds a single TPMPolicy command into an in-memory
+// trial session so its digest contribution can be folded into
+// TPM2_PolicyOR's branch list without talking to a TPM.
+func updateTrialSession(sess *tpm2.TrialSession, p *TPMPolicy) error {
+	switch tpm2.TPMCC(p.CommandCode) {
+	case tpm2.TPMCCPolicyPCR:
+		var params tpm2.PolicyPCR
+		if _, err := tpm2.Unmarshal(p.CommandPolicy, &params); err != nil {
+			return fmt.Errorf("unmarshal PolicyPCR params: %v", err)
+		}
+		return sess.PolicyPCR(params.Pcrs, params.PcrDigest.Buffer)
+	case tpm2.TPMCCPolicyAuthValue:
+		return sess.PolicyAuthValue()
+	case tpm2.TPMCCPolicyCommandCode:
+		var params tpm2.PolicyCommandCode
+		if _, err := tpm2.Unmarshal(p.CommandPolicy, &params); err != nil {
+			return fmt.Errorf("unmarshal PolicyCommandCode params: %v", err)
+		}
+		return sess.PolicyCommandCode(params.Code)
+	case tpm2.TPMCCPolicyLocality:
+		var params tpm2.PolicyLocality
+		if _, err := tpm2.Unmarshal(p.CommandPolicy, &params); err != nil {
+			return fmt.Errorf("unmarshal PolicyLocality params: %v", err)
+		}
+		return sess.PolicyLocality(params.Locality)
+	case tpm2.TPMCCPolicySecret:
+		var params tpm2.PolicySecret
+		if _, err := tpm2.Unmarshal(p.CommandPolicy, &params); err != nil {
+			return fmt.Errorf("unmarshal PolicySecret params: %v", err)
+		}
+		return sess.PolicySecret(params.AuthHandle.Handle, params.PolicyRef.Buffer)
+	case tpm2.TPMCCPolicySigned:
+		var params tpm2.PolicySigned
+		if _, err := tpm2.Unmarshal(p.CommandPolicy, &params); err != nil {
+			return fmt.Errorf("unmarshal PolicySigned params: %v", err)
+		}
+		return sess.PolicySigned(params.AuthObject.Handle, params.PolicyRef.Buffer)
+	default:
+		return fmt.Errorf("unsupported policy command code in trial session")
+	}
+}
+
+func executePolicySigned(tpm transport.TPMCloser, sess tpm2.Session, p *TPMPolicy, hooks PolicyHooks) error {
+	var params tpm2.PolicySigned
+	if _, err := tpm2.Unmarshal(p.CommandPolicy, &params); err != nil {
+		return fmt.Errorf("unmarshal PolicySigned params: %v", err)
+	}
+	if hooks.PolicySignedSigner == nil {
+		return fmt.Errorf("policy requires PolicySigned but no PolicySignedSigner hook was provided")
+	}
+	sig, err := hooks.PolicySignedSigner(sess.NonceTPM().Buffer, params.CpHashA.Buffer, params.PolicyRef.Buffer, params.Expiration)
+	if err != nil {
+		return fmt.Errorf("policy signed signer callback failed: %v", err)
+	}
+	_, err = tpm2.PolicySigned{
+		AuthObject:    params.AuthObject,
+		PolicySession: sess.Handle(),
+		NonceTPM:      sess.NonceTPM(),
+		CpHashA:       params.CpHashA,
+		PolicyRef:     params.PolicyRef,
+		Expiration:    params.Expiration,
+		Auth:          sig,
+	}.Execute(tpm)
+	return err
+}
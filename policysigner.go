@@ -0,0 +1,93 @@
+package keyfile
+
+import (
+	"crypto"
+	encasn1 "encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/uzb-dev/go-tpm-keyfiles/tpmcmd"
+	"github.com/uzb-dev/go-tpm-keyfiles/tpmcmd/session"
+)
+
+// policySigner is the crypto.Signer TPMKey.Signer hands back for keys that
+// carry a Policy or AuthPolicy. Each Sign call loads the key fresh through
+// the tpmcmd direct-style command API, resolves and satisfies its policy
+// into a session via resolveAuthSession, and issues TPM2_Sign under that
+// session, instead of the plain password auth NewTPMKeySigner sends.
+type policySigner struct {
+	t          *TPMKey
+	tpm        transport.TPMCloser
+	parentAuth []byte
+}
+
+// Public returns the signer's public key.
+func (s *policySigner) Public() crypto.PublicKey {
+	pub, err := s.t.PublicKey()
+	if err != nil {
+		return nil
+	}
+	return pub
+}
+
+// Sign loads s.t (importing it first via TPM2_Import if it's an
+// OIDImportbleKey), satisfies its policy, and runs TPM2_Sign over digest
+// under the resulting policy session.
+func (s *policySigner) Sign(_ io.Reader, digest []byte, _ crypto.SignerOpts) ([]byte, error) {
+	objectHandle, err := loadTPMKey(s.tpm, s.t.Parent, s.parentAuth, s.t)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading key: %v", err)
+	}
+	defer tpmcmd.FlushContext.Execute(s.tpm, session.Password(nil), tpmcmd.FlushContextReq{FlushHandle: objectHandle})
+
+	authSess, closer, err := s.t.resolveAuthSession(s.tpm)
+	if err != nil {
+		return nil, fmt.Errorf("failed satisfying policy: %v", err)
+	}
+	if authSess == nil {
+		return nil, fmt.Errorf("key has neither Policy nor AuthPolicy to satisfy")
+	}
+	defer closer()
+
+	signRsp, err := tpmcmd.Sign.Execute(s.tpm, session.Wrap(authSess), tpmcmd.SignReq{
+		KeyHandle: objectHandle,
+		Digest:    tpm2.TPM2BDigest{Buffer: digest},
+		// A Null scheme defers to the scheme baked into the key's own
+		// public area; TPMKey never creates keys with an ambiguous scheme.
+		InScheme:   tpm2.TPMTSigScheme{Scheme: tpm2.TPMAlgNull},
+		Validation: tpm2.TPMTTKHashcheck{Tag: tpm2.TPMSTHashcheck, Hierarchy: tpm2.TPMRHNull},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed signing: %v", err)
+	}
+
+	return marshalSignature(signRsp.Signature)
+}
+
+// marshalSignature converts a TPM2_Sign result into the byte encoding
+// crypto.Signer callers expect: raw PKCS#1v1.5 bytes for RSA, and ASN.1 DER
+// (R, S) for ECDSA, matching what TPMKey.Verify checks signatures against.
+func marshalSignature(sig tpm2.TPMTSignature) ([]byte, error) {
+	switch sig.SigAlg {
+	case tpm2.TPMAlgRSASSA:
+		rsaSig, err := sig.Signature.RSASSA()
+		if err != nil {
+			return nil, fmt.Errorf("failed reading rsa signature: %v", err)
+		}
+		return rsaSig.Sig.Buffer, nil
+	case tpm2.TPMAlgECDSA:
+		eccSig, err := sig.Signature.ECDSA()
+		if err != nil {
+			return nil, fmt.Errorf("failed reading ecdsa signature: %v", err)
+		}
+		return encasn1.Marshal(struct{ R, S *big.Int }{
+			R: new(big.Int).SetBytes(eccSig.SignatureR.Buffer),
+			S: new(big.Int).SetBytes(eccSig.SignatureS.Buffer),
+		})
+	default:
+		return nil, fmt.Errorf("unsupported signature algorithm")
+	}
+}
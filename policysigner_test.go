@@ -0,0 +1,61 @@
+package keyfile
+
+import (
+	"bytes"
+	encasn1 "encoding/asn1"
+	"math/big"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+func TestMarshalSignatureRSA(t *testing.T) {
+	want := []byte("a pkcs1v15 signature")
+	sig := tpm2.TPMTSignature{
+		SigAlg: tpm2.TPMAlgRSASSA,
+		Signature: tpm2.NewTPMUSignature(tpm2.TPMAlgRSASSA, &tpm2.TPMSSignatureRSA{
+			Hash: tpm2.TPMAlgSHA256,
+			Sig:  tpm2.TPM2BPublicKeyRSA{Buffer: want},
+		}),
+	}
+
+	got, err := marshalSignature(sig)
+	if err != nil {
+		t.Fatalf("failed marshaling rsa signature: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("rsa signature = %x, want %x", got, want)
+	}
+}
+
+func TestMarshalSignatureECDSA(t *testing.T) {
+	r := big.NewInt(12345)
+	s := big.NewInt(67890)
+	sig := tpm2.TPMTSignature{
+		SigAlg: tpm2.TPMAlgECDSA,
+		Signature: tpm2.NewTPMUSignature(tpm2.TPMAlgECDSA, &tpm2.TPMSSignatureECC{
+			Hash:       tpm2.TPMAlgSHA256,
+			SignatureR: tpm2.TPM2BECCParameter{Buffer: r.Bytes()},
+			SignatureS: tpm2.TPM2BECCParameter{Buffer: s.Bytes()},
+		}),
+	}
+
+	got, err := marshalSignature(sig)
+	if err != nil {
+		t.Fatalf("failed marshaling ecdsa signature: %v", err)
+	}
+
+	var parsed struct{ R, S *big.Int }
+	if _, err := encasn1.Unmarshal(got, &parsed); err != nil {
+		t.Fatalf("signature is not valid asn.1: %v", err)
+	}
+	if parsed.R.Cmp(r) != 0 || parsed.S.Cmp(s) != 0 {
+		t.Fatalf("decoded (R, S) = (%v, %v), want (%v, %v)", parsed.R, parsed.S, r, s)
+	}
+}
+
+func TestMarshalSignatureUnsupportedAlgorithm(t *testing.T) {
+	if _, err := marshalSignature(tpm2.TPMTSignature{SigAlg: tpm2.TPMAlgHMAC}); err == nil {
+		t.Fatalf("expected an error for an unsupported signature algorithm")
+	}
+}
@@ -0,0 +1,180 @@
+package keyfile
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/uzb-dev/go-tpm-keyfiles/tpmcmd"
+	"github.com/uzb-dev/go-tpm-keyfiles/tpmcmd/session"
+)
+
+// sealedAESKeySize is the size, in bytes, of the AES-256 key generated for
+// every sealed key. It is small enough to fit comfortably inside a
+// TPM2B_SENSITIVE_DATA buffer, unlike the application payload it wraps.
+const sealedAESKeySize = 32
+
+// NewSealedKey creates a sealed TPMKey (OIDSealedKey) binding data to the
+// TPM under parent, authorized with parentAuth. TPM2B_SENSITIVE_DATA
+// objects are capped at roughly 128 bytes by most TPMs, so data itself is
+// never given to the TPM directly: instead a fresh AES-256 key is generated
+// and sealed inside the TPM object, and data is AES-GCM encrypted under
+// that key and carried alongside the key file in EncryptedPayload. If
+// pcrSel is non-nil the object's authPolicy is set to a TPM2_PolicyPCR
+// digest over that selection, so Unseal will only succeed when the
+// platform's PCRs match.
+func NewSealedKey(tpm transport.TPMCloser, parent tpm2.TPMHandle, parentAuth, data, auth []byte, pcrSel *tpm2.TPMLPCRSelection, opts ...TPMKeyOption) (*TPMKey, error) {
+	aesKey := make([]byte, sealedAESKeySize)
+	if _, err := rand.Read(aesKey); err != nil {
+		return nil, fmt.Errorf("failed generating sealing key: %v", err)
+	}
+
+	encryptedPayload, err := sealPayload(aesKey, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed encrypting payload: %v", err)
+	}
+
+	inPublic := tpm2.TPMTPublic{
+		Type:    tpm2.TPMAlgKeyedHash,
+		NameAlg: tpm2.TPMAlgSHA256,
+		ObjectAttributes: tpm2.TPMAObject{
+			FixedTPM:        true,
+			FixedParent:     true,
+			UserWithAuth:    pcrSel == nil,
+			NoDA:            true,
+			AdminWithPolicy: pcrSel != nil,
+		},
+		Parameters: tpm2.NewTPMUPublicParms(tpm2.TPMAlgKeyedHash, &tpm2.TPMSKeyedHashParms{
+			Scheme: tpm2.TPMTKeyedHashScheme{Scheme: tpm2.TPMAlgNull},
+		}),
+	}
+
+	var policies []*TPMPolicy
+	if pcrSel != nil {
+		pcrDigest, digest, err := pcrPolicyDigest(tpm2.TPMAlgSHA256, *pcrSel)
+		if err != nil {
+			return nil, fmt.Errorf("failed computing pcr policy digest: %v", err)
+		}
+		inPublic.AuthPolicy = tpm2.TPM2BDigest{Buffer: digest}
+		policies = append(policies, &TPMPolicy{
+			CommandCode: int(tpm2.TPMCCPolicyPCR),
+			CommandPolicy: tpm2.Marshal(tpm2.PolicyPCR{
+				Pcrs:      *pcrSel,
+				PcrDigest: tpm2.TPM2BDigest{Buffer: pcrDigest},
+			}),
+		})
+	}
+
+	createRsp, err := tpm2.Create{
+		ParentHandle: tpm2.AuthHandle{Handle: parent, Auth: tpm2.PasswordAuth(parentAuth)},
+		InPublic:     tpm2.New2B(inPublic),
+		InSensitive: tpm2.TPM2BSensitiveCreate{
+			Sensitive: &tpm2.TPMSSensitiveCreate{
+				UserAuth: tpm2.TPM2BAuth{Buffer: auth},
+				Data:     tpm2.NewTPMUSensitiveCreate(&tpm2.TPM2BSensitiveData{Buffer: aesKey}),
+			},
+		},
+	}.Execute(tpm)
+	if err != nil {
+		return nil, fmt.Errorf("failed sealing key to tpm: %v", err)
+	}
+
+	key := NewTPMKey(OIDSealedKey, createRsp.OutPublic, createRsp.OutPrivate, opts...)
+	key.Parent = parent
+	key.EmptyAuth = len(auth) == 0
+	key.Policy = policies
+	key.EncryptedPayload = encryptedPayload
+	return key, nil
+}
+
+// Unseal loads a sealed TPMKey, recovers the AES key the TPM protects, and
+// decrypts EncryptedPayload with it, returning the original application
+// data. Load and Unseal are driven through the tpmcmd direct-style command
+// API; when the key carries AuthPolicy branches or a flat Policy,
+// resolveAuthSession replays it into a policy session instead of sending
+// auth as a plain password.
+func (t *TPMKey) Unseal(tpm transport.TPMCloser, ownerAuth, auth []byte) ([]byte, error) {
+	if !t.Keytype.Equal(OIDSealedKey) {
+		return nil, fmt.Errorf("key is not a sealed key")
+	}
+
+	loadRsp, err := tpmcmd.LoadKey.Execute(tpm, session.Password(ownerAuth), tpmcmd.LoadKeyReq{
+		ParentHandle: t.Parent,
+		InPublic:     t.Pubkey,
+		InPrivate:    t.Privkey,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed loading sealed object: %v", err)
+	}
+	defer tpmcmd.FlushContext.Execute(tpm, session.Password(nil), tpmcmd.FlushContextReq{FlushHandle: loadRsp.ObjectHandle})
+
+	authSess := tpm2.Session(tpm2.PasswordAuth(auth))
+	if policySess, closer, err := t.resolveAuthSession(tpm); err != nil {
+		return nil, fmt.Errorf("failed satisfying policy: %v", err)
+	} else if policySess != nil {
+		defer closer()
+		authSess = policySess
+	}
+
+	unsealRsp, err := tpmcmd.Unseal.Execute(tpm, session.Wrap(authSess), tpmcmd.UnsealReq{ItemHandle: loadRsp.ObjectHandle})
+	if err != nil {
+		return nil, fmt.Errorf("failed unsealing: %v", err)
+	}
+
+	return unsealPayload(unsealRsp.OutData.Buffer, t.EncryptedPayload)
+}
+
+func sealPayload(aesKey, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating aes cipher: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating gcm: %v", err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed generating nonce: %v", err)
+	}
+	return gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+func unsealPayload(aesKey, encryptedPayload []byte) ([]byte, error) {
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating aes cipher: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating gcm: %v", err)
+	}
+	if len(encryptedPayload) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted payload shorter than nonce")
+	}
+	nonce, ciphertext := encryptedPayload[:gcm.NonceSize()], encryptedPayload[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed decrypting payload: %v", err)
+	}
+	return plaintext, nil
+}
+
+// pcrPolicyDigest computes the policy digest a TPM2_PolicyPCR assertion over
+// sel would produce, for embedding in an object's authPolicy at creation
+// time, together with the PCR digest that assertion was computed over (the
+// pcrDigest parameter TPM2_PolicyPCR must be replayed with).
+func pcrPolicyDigest(alg tpm2.TPMAlgID, sel tpm2.TPMLPCRSelection) (pcrDigest, policyDigest []byte, err error) {
+	trial := tpm2.NewTrialSession(alg)
+	pcrDigest, err = tpm2.PCRDigest(trial, sel)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed computing pcr digest: %v", err)
+	}
+	if err := trial.PolicyPCR(sel, pcrDigest); err != nil {
+		return nil, nil, fmt.Errorf("failed updating trial session: %v", err)
+	}
+	return pcrDigest, trial.PolicyDigest(), nil
+}
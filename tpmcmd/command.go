@@ -0,0 +1,188 @@
+// Package tpmcmd implements a typed, direct-style request/response API for
+// the TPM 2.0 commands keyfile drives against a loaded TPMKey: LoadKey,
+// Sign, Unseal, Import, PolicySession and FlushContext. Each command is a
+// Command[Req, Rsp] value carrying typed parameter/result structs instead
+// of go-tpm's positional arguments, and takes a *session.Session so callers
+// can compose their own salted and parameter-encrypted HMAC sessions
+// without dropping down to go-tpm internals. The session package has no
+// audit option; an audit session built with go-tpm can be passed in
+// through session.Wrap.
+package tpmcmd
+
+import (
+	"fmt"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/uzb-dev/go-tpm-keyfiles/tpmcmd/session"
+)
+
+// Command pairs a TPM command code with the function that runs it, typed
+// over its request and response structs.
+type Command[Req any, Rsp any] struct {
+	Code tpm2.TPMCC
+	run  func(tpm transport.TPMCloser, sess *session.Session, req Req) (Rsp, error)
+}
+
+// Execute runs the command against tpm, using sess as the auth session on
+// the command's handle area.
+func (c Command[Req, Rsp]) Execute(tpm transport.TPMCloser, sess *session.Session, req Req) (Rsp, error) {
+	return c.run(tpm, sess, req)
+}
+
+// LoadKeyReq carries the parameters needed to load a TPMKey's public/private
+// blobs under an already-loaded parent.
+type LoadKeyReq struct {
+	ParentHandle tpm2.TPMHandle
+	InPublic     tpm2.TPM2BPublic
+	InPrivate    tpm2.TPM2BPrivate
+}
+
+// LoadKeyRsp is the handle and name the TPM assigned the loaded object.
+type LoadKeyRsp struct {
+	ObjectHandle tpm2.TPMHandle
+	Name         tpm2.TPM2BName
+}
+
+// LoadKey runs TPM2_Load.
+var LoadKey = Command[LoadKeyReq, LoadKeyRsp]{
+	Code: tpm2.TPMCCLoad,
+	run: func(tpm transport.TPMCloser, sess *session.Session, req LoadKeyReq) (LoadKeyRsp, error) {
+		rsp, err := tpm2.Load{
+			ParentHandle: tpm2.AuthHandle{Handle: req.ParentHandle, Auth: sess.Auth()},
+			InPublic:     req.InPublic,
+			InPrivate:    req.InPrivate,
+		}.Execute(tpm)
+		if err != nil {
+			return LoadKeyRsp{}, fmt.Errorf("TPM2_Load failed: %v", err)
+		}
+		return LoadKeyRsp{ObjectHandle: rsp.ObjectHandle, Name: rsp.Name}, nil
+	},
+}
+
+// SignReq carries the parameters for TPM2_Sign.
+type SignReq struct {
+	KeyHandle  tpm2.TPMHandle
+	Digest     tpm2.TPM2BDigest
+	InScheme   tpm2.TPMTSigScheme
+	Validation tpm2.TPMTTKHashcheck
+}
+
+// SignRsp is the produced signature.
+type SignRsp struct {
+	Signature tpm2.TPMTSignature
+}
+
+// Sign runs TPM2_Sign.
+var Sign = Command[SignReq, SignRsp]{
+	Code: tpm2.TPMCCSign,
+	run: func(tpm transport.TPMCloser, sess *session.Session, req SignReq) (SignRsp, error) {
+		rsp, err := tpm2.Sign{
+			KeyHandle:  tpm2.AuthHandle{Handle: req.KeyHandle, Auth: sess.Auth()},
+			Digest:     req.Digest,
+			InScheme:   req.InScheme,
+			Validation: req.Validation,
+		}.Execute(tpm)
+		if err != nil {
+			return SignRsp{}, fmt.Errorf("TPM2_Sign failed: %v", err)
+		}
+		return SignRsp{Signature: rsp.Signature}, nil
+	},
+}
+
+// UnsealReq carries the parameters for TPM2_Unseal.
+type UnsealReq struct {
+	ItemHandle tpm2.TPMHandle
+}
+
+// UnsealRsp is the recovered sensitive data.
+type UnsealRsp struct {
+	OutData tpm2.TPM2BSensitiveData
+}
+
+// Unseal runs TPM2_Unseal. When sess is parameter-encrypted, OutData comes
+// back decrypted transparently by go-tpm's session layer.
+var Unseal = Command[UnsealReq, UnsealRsp]{
+	Code: tpm2.TPMCCUnseal,
+	run: func(tpm transport.TPMCloser, sess *session.Session, req UnsealReq) (UnsealRsp, error) {
+		rsp, err := tpm2.Unseal{
+			ItemHandle: tpm2.AuthHandle{Handle: req.ItemHandle, Auth: sess.Auth()},
+		}.Execute(tpm)
+		if err != nil {
+			return UnsealRsp{}, fmt.Errorf("TPM2_Unseal failed: %v", err)
+		}
+		return UnsealRsp{OutData: rsp.OutData}, nil
+	},
+}
+
+// ImportReq carries the parameters for TPM2_Import.
+type ImportReq struct {
+	ParentHandle tpm2.TPMHandle
+	ObjectPublic tpm2.TPM2BPublic
+	Duplicate    tpm2.TPM2BPrivate
+	InSymSeed    tpm2.TPM2BEncryptedSecret
+}
+
+// ImportRsp is the resulting loadable private area.
+type ImportRsp struct {
+	OutPrivate tpm2.TPM2BPrivate
+}
+
+// Import runs TPM2_Import.
+var Import = Command[ImportReq, ImportRsp]{
+	Code: tpm2.TPMCCImport,
+	run: func(tpm transport.TPMCloser, sess *session.Session, req ImportReq) (ImportRsp, error) {
+		rsp, err := tpm2.Import{
+			ParentHandle: tpm2.AuthHandle{Handle: req.ParentHandle, Auth: sess.Auth()},
+			ObjectPublic: req.ObjectPublic,
+			Duplicate:    req.Duplicate,
+			InSymSeed:    req.InSymSeed,
+		}.Execute(tpm)
+		if err != nil {
+			return ImportRsp{}, fmt.Errorf("TPM2_Import failed: %v", err)
+		}
+		return ImportRsp{OutPrivate: rsp.OutPrivate}, nil
+	},
+}
+
+// PolicySessionReq carries the parameters needed to open a policy session.
+type PolicySessionReq struct {
+	HashAlg tpm2.TPMAlgID
+}
+
+// PolicySessionRsp is an opened policy session. The caller drives whatever
+// TPM2_Policy* commands it needs directly against Handle, then uses it as
+// the auth session on a later command; Close releases it.
+type PolicySessionRsp struct {
+	Handle tpm2.Session
+	Close  func() error
+}
+
+// PolicySession runs TPM2_StartAuthSession in policy mode.
+var PolicySession = Command[PolicySessionReq, PolicySessionRsp]{
+	Code: tpm2.TPMCCStartAuthSession,
+	run: func(tpm transport.TPMCloser, _ *session.Session, req PolicySessionReq) (PolicySessionRsp, error) {
+		sess, closer, err := tpm2.PolicySession(tpm, req.HashAlg, 16)
+		if err != nil {
+			return PolicySessionRsp{}, fmt.Errorf("TPM2_StartAuthSession failed: %v", err)
+		}
+		return PolicySessionRsp{Handle: sess, Close: closer}, nil
+	},
+}
+
+// FlushContextReq names the handle to flush.
+type FlushContextReq struct {
+	FlushHandle tpm2.TPMHandle
+}
+
+// FlushContext runs TPM2_FlushContext. It ignores the session it is given,
+// since the handle it flushes carries no auth.
+var FlushContext = Command[FlushContextReq, struct{}]{
+	Code: tpm2.TPMCCFlushContext,
+	run: func(tpm transport.TPMCloser, _ *session.Session, req FlushContextReq) (struct{}, error) {
+		if _, err := (tpm2.FlushContext{FlushHandle: req.FlushHandle}).Execute(tpm); err != nil {
+			return struct{}{}, fmt.Errorf("TPM2_FlushContext failed: %v", err)
+		}
+		return struct{}{}, nil
+	},
+}
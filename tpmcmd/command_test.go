@@ -0,0 +1,27 @@
+package tpmcmd
+
+import (
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+func TestCommandCodes(t *testing.T) {
+	cases := []struct {
+		name string
+		got  tpm2.TPMCC
+		want tpm2.TPMCC
+	}{
+		{"LoadKey", LoadKey.Code, tpm2.TPMCCLoad},
+		{"Sign", Sign.Code, tpm2.TPMCCSign},
+		{"Unseal", Unseal.Code, tpm2.TPMCCUnseal},
+		{"Import", Import.Code, tpm2.TPMCCImport},
+		{"PolicySession", PolicySession.Code, tpm2.TPMCCStartAuthSession},
+		{"FlushContext", FlushContext.Code, tpm2.TPMCCFlushContext},
+	}
+	for _, c := range cases {
+		if c.got != c.want {
+			t.Errorf("%s.Code = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+}
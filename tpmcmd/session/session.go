@@ -0,0 +1,100 @@
+// Package session builds go-tpm auth sessions for use by tpmcmd commands.
+// It wraps the positional-argument session helpers in go-tpm/tpm2 behind a
+// small, composable Session type so callers can ask for an HMAC session
+// that is additionally salted against a parent and/or parameter-encrypted,
+// without juggling the underlying option list themselves.
+package session
+
+import (
+	"fmt"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+// Session wraps a go-tpm auth session together with the bits of state
+// tpmcmd commands need to decide how to use it (e.g. whether responses are
+// parameter-encrypted and therefore need decrypting on the way out).
+type Session struct {
+	auth       tpm2.Session
+	closer     func() error
+	encryption bool
+}
+
+// Option configures a Session at construction time.
+type Option func(*options)
+
+type options struct {
+	tpmOpts    []tpm2.AuthOption
+	encryption bool
+}
+
+// Salted binds the session to parentHandle/parentPub so its session key is
+// derived under a secret only that parent (and the TPM) can recover.
+func Salted(parentHandle tpm2.TPMHandle, parentPub tpm2.TPMTPublic) Option {
+	return func(o *options) {
+		o.tpmOpts = append(o.tpmOpts, tpm2.Salted(parentHandle, parentPub))
+	}
+}
+
+// WithParameterEncryption turns on AES parameter encryption for the first
+// response parameter, so e.g. an Unseal result is never sent back in the
+// clear even over a software transport.
+func WithParameterEncryption() Option {
+	return func(o *options) {
+		o.tpmOpts = append(o.tpmOpts, tpm2.AESEncryption(128, tpm2.EncryptOut))
+		o.encryption = true
+	}
+}
+
+// Password wraps a plain password authorization as a Session, for callers
+// that need to drive a tpmcmd command with simple handle-password auth
+// (e.g. a storage hierarchy or parent password) instead of an HMAC or
+// policy session. Close is a no-op: there is no TPM-side session to
+// release.
+func Password(auth []byte) *Session {
+	return &Session{auth: tpm2.PasswordAuth(auth)}
+}
+
+// Wrap adapts an already-open tpm2.Session, such as a policy session
+// returned by TPMKey.PolicySession or SatisfyAuthPolicy, for use as a
+// tpmcmd command's auth session. Close is a no-op: the caller that opened
+// sess remains responsible for releasing it.
+func Wrap(sess tpm2.Session) *Session {
+	return &Session{auth: sess}
+}
+
+// New opens an HMAC auth session against tpm configured with opts. The
+// returned Session's Close must be called once it is no longer needed.
+func New(tpm transport.TPMCloser, alg tpm2.TPMAlgID, opts ...Option) (*Session, error) {
+	var o options
+	for _, fn := range opts {
+		fn(&o)
+	}
+
+	hmacSess, closer, err := tpm2.HMACSession(tpm, alg, 16, o.tpmOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed starting hmac session: %v", err)
+	}
+
+	return &Session{auth: hmacSess, closer: closer, encryption: o.encryption}, nil
+}
+
+// Auth returns the underlying tpm2.Session for use as a command's auth
+// session.
+func (s *Session) Auth() tpm2.Session {
+	return s.auth
+}
+
+// Encrypted reports whether this session negotiated parameter encryption.
+func (s *Session) Encrypted() bool {
+	return s.encryption
+}
+
+// Close releases the session's TPM-side resources.
+func (s *Session) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer()
+}
@@ -0,0 +1,52 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+func TestPasswordWrapsAuth(t *testing.T) {
+	s := Password([]byte("secret"))
+	if s.Auth() == nil {
+		t.Fatalf("Password did not produce a usable auth session")
+	}
+	if s.Encrypted() {
+		t.Fatalf("Password session should not report parameter encryption")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close on a Password session should be a no-op: %v", err)
+	}
+}
+
+func TestPasswordDistinctAuthValues(t *testing.T) {
+	// Sanity check that two different auth values each yield a usable
+	// session. Session doesn't expose the raw bytes, so the auth values
+	// themselves are not compared here.
+	a := Password([]byte("one"))
+	b := Password([]byte("two"))
+	if a.Auth() == nil || b.Auth() == nil {
+		t.Fatalf("Password sessions should carry a non-nil auth")
+	}
+}
+
+func TestWrapAdaptsExistingSession(t *testing.T) {
+	inner := tpm2.PasswordAuth([]byte("parent"))
+	s := Wrap(inner)
+	if s.Auth() != inner {
+		t.Fatalf("Wrap should return the exact session it was given")
+	}
+	if s.Encrypted() {
+		t.Fatalf("Wrap session should not report parameter encryption")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close on a Wrap session should be a no-op: %v", err)
+	}
+}
+
+func TestPasswordEmptyAuth(t *testing.T) {
+	s := Password(nil)
+	if s.Auth() == nil {
+		t.Fatalf("Password(nil) should still produce a usable auth session")
+	}
+}